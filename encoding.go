@@ -0,0 +1,65 @@
+package xweb
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"sort"
+	"sync"
+)
+
+const (
+	mimeJSON    = "application/json"
+	mimeXML     = "application/xml"
+	mimeYAML    = "application/x-yaml"
+	mimeMsgpack = "application/x-msgpack"
+)
+
+// EncoderFunc marshals obj and writes its encoding to w.
+type EncoderFunc func(w io.Writer, obj interface{}) error
+
+var (
+	encodersMutex sync.RWMutex
+	encoders      = map[string]EncoderFunc{
+		mimeJSON: func(w io.Writer, obj interface{}) error {
+			return json.NewEncoder(w).Encode(obj)
+		},
+		mimeXML: func(w io.Writer, obj interface{}) error {
+			return xml.NewEncoder(w).Encode(obj)
+		},
+	}
+)
+
+// RegisterEncoder registers an encoder for mime, so Auto can dispatch to
+// it when a client's Accept header asks for that type. Registering
+// application/json or application/xml replaces the map entry, but Auto
+// still writes those two types through ServeJson and ServeXml, so the
+// registered function is not called for them.
+func RegisterEncoder(mime string, fn EncoderFunc) {
+	encodersMutex.Lock()
+	defer encodersMutex.Unlock()
+	encoders[mime] = fn
+}
+
+func getEncoder(mime string) (EncoderFunc, bool) {
+	encodersMutex.RLock()
+	defer encodersMutex.RUnlock()
+	fn, ok := encoders[mime]
+	return fn, ok
+}
+
+// registeredMimeTypes returns the registered mime types in a stable,
+// sorted order. Auto's negotiateContentType picks the first offer that
+// matches a wildcard Accept type, so an unordered list (ranging over
+// encoders directly) would let Go's randomized map iteration pick a
+// different encoder for the same request from one call to the next.
+func registeredMimeTypes() []string {
+	encodersMutex.RLock()
+	defer encodersMutex.RUnlock()
+	types := make([]string, 0, len(encoders))
+	for mime := range encoders {
+		types = append(types, mime)
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,71 @@
+package xweb
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// acceptSpec is one media range parsed out of an Accept header, together
+// with its quality value.
+type acceptSpec struct {
+	mimeType string
+	q        float64
+}
+
+// parseAccept parses an Accept header into its component media ranges,
+// ordered from most to least preferred according to the q parameter
+// (RFC 7231 5.3.2). Entries without an explicit q default to 1.
+func parseAccept(header string) []acceptSpec {
+	var specs []acceptSpec
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.Split(part, ";")
+		mimeType := strings.TrimSpace(fields[0])
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if f, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = f
+				}
+			}
+		}
+		specs = append(specs, acceptSpec{mimeType: mimeType, q: q})
+	}
+	sort.SliceStable(specs, func(i, j int) bool { return specs[i].q > specs[j].q })
+	return specs
+}
+
+// negotiateContentType picks the offer the client's Accept header prefers
+// most, honoring q values and "type/*"/"*/*" wildcards, similar in spirit
+// to httputil.NegotiateContentType. It returns defaultOffer when the
+// header is empty or nothing in offers matches.
+func negotiateContentType(accept string, offers []string, defaultOffer string) string {
+	if accept == "" {
+		return defaultOffer
+	}
+	for _, spec := range parseAccept(accept) {
+		if spec.q <= 0 {
+			continue
+		}
+		if spec.mimeType == "*/*" {
+			return defaultOffer
+		}
+		for _, offer := range offers {
+			if spec.mimeType == offer {
+				return offer
+			}
+			if strings.HasSuffix(spec.mimeType, "/*") {
+				prefix := strings.TrimSuffix(spec.mimeType, "*")
+				if strings.HasPrefix(offer, prefix) {
+					return offer
+				}
+			}
+		}
+	}
+	return defaultOffer
+}
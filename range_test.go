@@ -0,0 +1,58 @@
+package xweb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	const size = 1000
+
+	cases := []struct {
+		name   string
+		header string
+		want   []httpRange
+		err    bool
+	}{
+		{name: "no header", header: "", want: nil},
+		{name: "missing bytes prefix", header: "0-99", err: true},
+		{name: "simple range", header: "bytes=0-99", want: []httpRange{{start: 0, length: 100}}},
+		{name: "open ended range", header: "bytes=900-", want: []httpRange{{start: 900, length: 100}}},
+		{name: "suffix range", header: "bytes=-500", want: []httpRange{{start: 500, length: 500}}},
+		{name: "suffix range larger than size", header: "bytes=-5000", want: []httpRange{{start: 0, length: 1000}}},
+		{name: "end clamped to size", header: "bytes=0-5000", want: []httpRange{{start: 0, length: 1000}}},
+		{
+			name:   "multiple ranges",
+			header: "bytes=0-99,200-299",
+			want:   []httpRange{{start: 0, length: 100}, {start: 200, length: 100}},
+		},
+		{name: "start beyond size with no overlap", header: "bytes=1000-1099", err: true},
+		{name: "start after end", header: "bytes=100-50", err: true},
+		{name: "garbage", header: "bytes=abc-def", err: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseRange(c.header, size)
+			if c.err {
+				if err == nil {
+					t.Fatalf("parseRange(%q) = %v, want error", c.header, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRange(%q) returned unexpected error: %v", c.header, err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("parseRange(%q) = %v, want %v", c.header, got, c.want)
+			}
+		})
+	}
+}
+
+func TestSumRangesSize(t *testing.T) {
+	ranges := []httpRange{{start: 0, length: 100}, {start: 200, length: 50}}
+	if got := sumRangesSize(ranges); got != 150 {
+		t.Fatalf("sumRangesSize(%v) = %d, want 150", ranges, got)
+	}
+}
@@ -6,30 +6,48 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type ResponseType int
 
 const (
-	AutoResponse  = iota + 1
+	AutoResponse = iota + 1
 	JsonResponse
 	XmlResponse
+	YamlResponse
+	MsgpackResponse
 )
 
 type ResponseWriter struct {
 	resp       http.ResponseWriter
+	req        *http.Request
 	buffer     []byte
 	StatusCode int
 	header     http.Header
+	// Streaming, once enabled by StartStreaming, makes Write pass data
+	// straight through to the underlying http.ResponseWriter instead of
+	// accumulating it in buffer. Used by handlers that stream their
+	// response body (ranged downloads, archives, SSE) rather than
+	// building it up front.
+	Streaming bool
+	// ResponseType controls how Auto picks a representation: leave it
+	// zero or set it to AutoResponse to negotiate off the request's
+	// Accept header, or pin it to JsonResponse/XmlResponse/... to force
+	// a format regardless of what the client asked for.
+	ResponseType ResponseType
 }
 
-func NewResponseWriter(resp http.ResponseWriter) *ResponseWriter {
+func NewResponseWriter(resp http.ResponseWriter, req *http.Request) *ResponseWriter {
 	return &ResponseWriter{
 		resp:       resp,
+		req:        req,
 		buffer:     make([]byte, 0),
 		StatusCode: 0,
 		header:     make(map[string][]string),
@@ -40,14 +58,47 @@ func (r *ResponseWriter) Header() http.Header {
 	return r.header
 }
 
+// Request returns the *http.Request this ResponseWriter was created for.
+func (r *ResponseWriter) Request() *http.Request {
+	return r.req
+}
+
 func (r *ResponseWriter) Write(data []byte) (int, error) {
 	if r.StatusCode == 0 {
 		r.StatusCode = http.StatusOK
 	}
+	if r.Streaming {
+		return r.resp.Write(data)
+	}
 	r.buffer = append(r.buffer, data...)
 	return len(data), nil
 }
 
+// StartStreaming flushes the headers accumulated so far straight to the
+// underlying http.ResponseWriter and switches Write into passthrough
+// mode, bypassing buffer. Call it once the status code and headers for
+// a streamed response (ranged content, archives, SSE, ...) are final;
+// afterwards Flush only calls the underlying http.Flusher, if any.
+func (r *ResponseWriter) StartStreaming() {
+	if r.Streaming {
+		return
+	}
+	if r.StatusCode == 0 {
+		r.StatusCode = http.StatusOK
+	}
+	for key, value := range r.header {
+		if len(value) == 1 {
+			r.resp.Header().Set(key, value[0])
+		} else {
+			for _, v := range value {
+				r.resp.Header().Add(key, v)
+			}
+		}
+	}
+	r.resp.WriteHeader(r.StatusCode)
+	r.Streaming = true
+}
+
 func (r *ResponseWriter) Written() bool {
 	return r.StatusCode != 0
 }
@@ -57,11 +108,28 @@ func (r *ResponseWriter) WriteHeader(code int) {
 }
 
 func (r *ResponseWriter) ServeFile(req *http.Request, path string) error {
-	http.ServeFile(r, req, path)
-	if r.StatusCode != http.StatusOK {
-		return errors.New("serve file failed")
+	f, err := os.Open(path)
+	if err != nil {
+		http.ServeFile(r, req, path)
+		if r.StatusCode != http.StatusOK {
+			return errors.New("serve file failed")
+		}
+		return nil
 	}
-	return nil
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		// directory listings and index.html resolution are left to the
+		// standard library helper.
+		http.ServeFile(r, req, path)
+		if r.StatusCode != http.StatusOK {
+			return errors.New("serve file failed")
+		}
+		return nil
+	}
+
+	return r.ServeContent(req, fi.Name(), fi.ModTime(), f)
 }
 
 func (r *ResponseWriter) ServeReader(rd io.Reader) error {
@@ -93,7 +161,72 @@ func (r *ResponseWriter) ServeJson(obj interface{}) error {
 	return err
 }
 
-func (r *ResponseWriter) Download(fpath string) error {
+// ServeYaml writes obj encoded as YAML. It has no built-in encoder; call
+// RegisterEncoder(mimeYAML, ...) with a YAML library of your choice (e.g.
+// gopkg.in/yaml.v2) before using it or Auto with a client asking for it.
+func (r *ResponseWriter) ServeYaml(obj interface{}) error {
+	return r.serveEncoded(mimeYAML, obj)
+}
+
+// ServeMsgpack writes obj encoded as MessagePack. It has no built-in
+// encoder; call RegisterEncoder(mimeMsgpack, ...) with a msgpack library
+// of your choice before using it or Auto with a client asking for it.
+func (r *ResponseWriter) ServeMsgpack(obj interface{}) error {
+	return r.serveEncoded(mimeMsgpack, obj)
+}
+
+func (r *ResponseWriter) serveEncoded(mime string, obj interface{}) error {
+	fn, ok := getEncoder(mime)
+	if !ok {
+		return fmt.Errorf("xweb: no encoder registered for %s", mime)
+	}
+	r.Header().Set("Content-Type", mime)
+	return fn(r, obj)
+}
+
+// Auto picks a response representation for obj by negotiating the
+// request's Accept header against the encoders known to RegisterEncoder
+// (application/json and application/xml are registered by default), then
+// writes it. Setting ResponseType to JsonResponse, XmlResponse,
+// YamlResponse or MsgpackResponse instead of AutoResponse skips
+// negotiation and forces that format.
+func (r *ResponseWriter) Auto(obj interface{}) error {
+	switch r.ResponseType {
+	case JsonResponse:
+		return r.ServeJson(obj)
+	case XmlResponse:
+		return r.ServeXml(obj)
+	case YamlResponse:
+		return r.ServeYaml(obj)
+	case MsgpackResponse:
+		return r.ServeMsgpack(obj)
+	}
+
+	accept := ""
+	if r.req != nil {
+		accept = r.req.Header.Get("Accept")
+	}
+
+	mimeType := negotiateContentType(accept, registeredMimeTypes(), mimeJSON)
+	switch mimeType {
+	case mimeXML:
+		return r.ServeXml(obj)
+	case mimeYAML:
+		return r.ServeYaml(obj)
+	case mimeMsgpack:
+		return r.ServeMsgpack(obj)
+	case mimeJSON:
+		return r.ServeJson(obj)
+	default:
+		return r.serveEncoded(mimeType, obj)
+	}
+}
+
+// Download sends fpath to the client as an attachment. If req is given,
+// the download is served through ServeContent so that range requests and
+// conditional GETs (resumable downloads) are honored; without it the
+// whole file is copied into the buffer as before.
+func (r *ResponseWriter) Download(fpath string, req ...*http.Request) error {
 	f, err := os.Open(fpath)
 	if err != nil {
 		return err
@@ -102,10 +235,136 @@ func (r *ResponseWriter) Download(fpath string) error {
 
 	fName := filepath.Base(fpath)
 	r.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%v\"", fName))
+
+	if len(req) > 0 && req[0] != nil {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		return r.ServeContent(req[0], fName, fi.ModTime(), f)
+	}
+
 	_, err = io.Copy(r, f)
 	return err
 }
 
+// ServeContent replies to the request using the content in the provided
+// ReadSeeker, mirroring the semantics of http.ServeContent: it handles
+// If-Modified-Since/If-None-Match, single and multiple byte ranges
+// (replying with multipart/byteranges when more than one range is
+// requested), and sets Content-Range/Accept-Ranges appropriately. Unlike
+// the rest of ResponseWriter it streams the body straight through to the
+// underlying http.ResponseWriter via StartStreaming instead of buffering
+// it, so it is suitable for large files.
+//
+// If an ETag header has already been set (for example from a
+// StaticVerMgr digest) it is used as-is; otherwise an ETag is derived
+// from modTime and the content size.
+func (r *ResponseWriter) ServeContent(req *http.Request, name string, modTime time.Time, content io.ReadSeeker) error {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if r.Header().Get("Content-Type") == "" {
+		ctype := mime.TypeByExtension(filepath.Ext(name))
+		if ctype == "" {
+			var buf [512]byte
+			n, _ := io.ReadFull(content, buf[:])
+			ctype = http.DetectContentType(buf[:n])
+			if _, err := content.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		r.Header().Set("Content-Type", ctype)
+	}
+
+	etag := r.Header().Get("ETag")
+	if etag == "" {
+		etag = fmt.Sprintf("\"%x-%x\"", modTime.Unix(), size)
+		r.Header().Set("ETag", etag)
+	}
+
+	if checkNotModified(req, modTime, etag) {
+		r.Header().Del("Content-Type")
+		r.Header().Del("Content-Length")
+		r.NotModified()
+		return nil
+	}
+
+	r.Header().Set("Accept-Ranges", "bytes")
+
+	ranges, err := parseRange(req.Header.Get("Range"), size)
+	if err != nil {
+		if err == errNoOverlap {
+			r.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		}
+		return r.Abort(http.StatusRequestedRangeNotSatisfiable, err.Error())
+	}
+	if sumRangesSize(ranges) > size {
+		ranges = nil
+	}
+
+	contentType := r.Header().Get("Content-Type")
+
+	switch len(ranges) {
+	case 0:
+		r.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		r.WriteHeader(http.StatusOK)
+		r.StartStreaming()
+		_, err = io.Copy(r, content)
+		return err
+
+	case 1:
+		ra := ranges[0]
+		if _, err := content.Seek(ra.start, io.SeekStart); err != nil {
+			return err
+		}
+		r.Header().Set("Content-Length", strconv.FormatInt(ra.length, 10))
+		r.Header().Set("Content-Range", ra.contentRange(size))
+		r.WriteHeader(http.StatusPartialContent)
+		r.StartStreaming()
+		_, err = io.CopyN(r, content, ra.length)
+		return err
+
+	default:
+		encSize, boundary := rangesMIMESize(ranges, contentType, size)
+		r.Header().Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+		r.Header().Set("Content-Length", strconv.FormatInt(encSize, 10))
+		r.WriteHeader(http.StatusPartialContent)
+		r.StartStreaming()
+		return writeRanges(r, content, ranges, contentType, size, boundary)
+	}
+}
+
+// checkNotModified reports whether req's conditional headers (If-None-
+// Match taking precedence over If-Modified-Since, per RFC 7232) are
+// satisfied by etag/modTime, meaning the handler should reply 304.
+func checkNotModified(req *http.Request, modTime time.Time, etag string) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		if inm == "*" {
+			return true
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			if strings.TrimSpace(candidate) == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := req.Header.Get("If-Modified-Since"); ims != "" && !modTime.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err == nil && !modTime.Truncate(time.Second).After(t) {
+			return true
+		}
+	}
+	return false
+}
+
 func redirect(w http.ResponseWriter, url string, status ...int) error {
 	s := 302
 	if len(status) > 0 {
@@ -153,6 +412,13 @@ func (w *ResponseWriter) SetHeader(key string, value string) {
 func (r *ResponseWriter) Flush() error {
 	//fmt.Println("responsewriter:", r)
 
+	if r.Streaming {
+		if flusher, ok := r.resp.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		return nil
+	}
+
 	if r.StatusCode == 0 {
 		r.StatusCode = http.StatusOK
 	}
@@ -188,6 +454,13 @@ type HttpResponseInterface interface {
 	SetResponse(http.ResponseWriter)
 }
 
+// AutoResponder lets an action hand its result object back to the
+// Responses interceptor instead of calling ServeJson/ServeXml/... itself;
+// the interceptor picks the representation via ResponseWriter.Auto.
+type AutoResponder interface {
+	AutoResponse() (interface{}, error)
+}
+
 type Responses struct {
 }
 
@@ -200,6 +473,17 @@ func (ii *Responses) Intercept(ctx *Context) {
 		if s, ok := action.(ResponseInterface); ok {
 			s.SetResponse(ctx.Resp())
 		}
+
+		if s, ok := action.(AutoResponder); ok {
+			ctx.Invoke()
+			obj, err := s.AutoResponse()
+			if err != nil {
+				ctx.Resp().Abort(http.StatusInternalServerError, err.Error())
+				return
+			}
+			ctx.Resp().Auto(obj)
+			return
+		}
 	}
 
 	ctx.Invoke()
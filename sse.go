@@ -0,0 +1,164 @@
+package xweb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Hijack lets callers upgrade the connection to raw TCP, e.g. for a
+// websocket library, bypassing ResponseWriter's buffering entirely.
+func (r *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := r.resp.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("xweb: underlying http.ResponseWriter does not support hijacking")
+	}
+	return hj.Hijack()
+}
+
+// SSEStream is a Server-Sent Events connection opened by
+// ResponseWriter.SSE. It owns the connection outright (via Hijack), so
+// Send writes straight to the socket and Close shuts it down; there is
+// no separate goroutine per Send racing the handler's return.
+type SSEStream struct {
+	conn  net.Conn
+	bufrw *bufio.ReadWriter
+
+	// Retry sets the client's reconnection delay in milliseconds (the
+	// "retry:" field) included on every frame sent from here on. 0
+	// omits it, leaving the client's own default in place.
+	Retry int
+
+	writeMu sync.Mutex // serializes Send and Close against each other; the watcher does not take it
+	closed  int32      // atomic; set by Close or by the context watcher
+	nextID  int64
+}
+
+// SSE puts the response into Server-Sent Events mode: it hijacks the
+// underlying connection (writing the text/event-stream status line and
+// headers itself, since hijacking bypasses the std ResponseWriter that
+// would otherwise do it) and returns a stream to send events on. It
+// fails if the underlying http.ResponseWriter doesn't support Hijack
+// (e.g. HTTP/2).
+//
+// If req is non-nil, a background goroutine watches req.Context().Done()
+// for the lifetime of the stream and, the moment the client disconnects,
+// sets a write deadline in the past on the raw connection - which per
+// net.Conn's contract unblocks any Write already in progress with an
+// error instead of leaving it, and its caller, parked forever.
+func (r *ResponseWriter) SSE() (*SSEStream, error) {
+	r.Header().Set("Content-Type", "text/event-stream")
+	r.Header().Set("Cache-Control", "no-cache")
+	r.Header().Set("Connection", "keep-alive")
+	r.WriteHeader(http.StatusOK)
+
+	conn, bufrw, err := r.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(bufrw, "HTTP/1.1 %d %s\r\n", r.StatusCode, http.StatusText(r.StatusCode))
+	r.Header().Write(bufrw)
+	io.WriteString(bufrw, "\r\n")
+	if err := bufrw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	stream := &SSEStream{conn: conn, bufrw: bufrw}
+	if req := r.Request(); req != nil {
+		go stream.watchCancellation(req.Context().Done())
+	}
+	return stream, nil
+}
+
+// watchCancellation force-closes the connection as soon as done fires,
+// so a client that vanishes mid-event can't leave Send (or its caller)
+// blocked forever, and so the hijacked conn isn't leaked for streams
+// whose handler is itself blocked in a Send that will never return. It
+// sets a past write deadline first so a write already in progress wakes
+// up with an error rather than racing the Close underneath it.
+func (s *SSEStream) watchCancellation(done <-chan struct{}) {
+	<-done
+	s.conn.SetWriteDeadline(time.Unix(0, 1))
+	s.closeConn()
+}
+
+// Send writes a single SSE frame with the given event name (omitted if
+// "") and data, splitting data on "\n" into multiple "data:" lines as
+// the format requires.
+func (s *SSEStream) Send(event, data string) error {
+	if atomic.LoadInt32(&s.closed) != 0 {
+		return errors.New("xweb: SSE stream closed")
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if atomic.LoadInt32(&s.closed) != 0 {
+		return errors.New("xweb: SSE stream closed")
+	}
+
+	id := atomic.AddInt64(&s.nextID, 1)
+	frame := buildSSEFrame(event, data, strconv.FormatInt(id, 10), s.Retry)
+
+	if _, err := s.bufrw.Write(frame); err != nil {
+		return err
+	}
+	return s.bufrw.Flush()
+}
+
+// SendJSON marshals v and sends it as the data of an event named event.
+func (s *SSEStream) SendJSON(event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.Send(event, string(data))
+}
+
+// Close ends the stream and closes the underlying connection. It waits
+// for any Send in progress to finish first, so a write already underway
+// never races Close tearing the connection down.
+func (s *SSEStream) Close() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.closeConn()
+}
+
+// closeConn marks the stream closed and closes conn exactly once, no
+// matter whether Close or watchCancellation gets there first - both call
+// this instead of closing s.conn directly, so the connection is never
+// left open (leaked) nor double-closed.
+func (s *SSEStream) closeConn() error {
+	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+func buildSSEFrame(event, data, id string, retryMS int) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "id: %s\n", id)
+	if event != "" {
+		fmt.Fprintf(&buf, "event: %s\n", event)
+	}
+	if retryMS > 0 {
+		fmt.Fprintf(&buf, "retry: %d\n", retryMS)
+	}
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
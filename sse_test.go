@@ -0,0 +1,94 @@
+package xweb
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestSSEStream(t *testing.T) (*SSEStream, net.Conn) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			if _, err := client.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	return &SSEStream{
+		conn:  server,
+		bufrw: bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)),
+	}, server
+}
+
+func TestSSEStreamSendAndClose(t *testing.T) {
+	stream, _ := newTestSSEStream(t)
+
+	if err := stream.Send("ping", "hello"); err != nil {
+		t.Fatalf("Send returned unexpected error: %v", err)
+	}
+
+	if err := stream.Close(); err != nil {
+		t.Fatalf("Close returned unexpected error: %v", err)
+	}
+
+	if err := stream.Send("ping", "after close"); err == nil {
+		t.Fatal("Send after Close should fail")
+	}
+
+	// Close must actually close the underlying conn, not just flip the
+	// flag - writing to it directly should now fail.
+	if _, err := stream.conn.Write([]byte("x")); err == nil {
+		t.Fatal("conn should be closed after Close, but Write succeeded")
+	}
+}
+
+func TestSSEStreamCloseIsIdempotent(t *testing.T) {
+	stream, _ := newTestSSEStream(t)
+
+	if err := stream.Close(); err != nil {
+		t.Fatalf("first Close returned unexpected error: %v", err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatalf("second Close returned unexpected error: %v", err)
+	}
+}
+
+// TestSSEStreamWatchCancellationClosesConn reproduces the leak the
+// request-6 review flagged: on context cancellation, the watcher must
+// actually close the hijacked connection (not just mark the stream
+// closed), and a later explicit Close must not double-close it.
+func TestSSEStreamWatchCancellationClosesConn(t *testing.T) {
+	stream, _ := newTestSSEStream(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		stream.watchCancellation(ctx.Done())
+		close(done)
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("watchCancellation did not return after context cancellation")
+	}
+
+	if _, err := stream.conn.Write([]byte("x")); err == nil {
+		t.Fatal("conn should be closed once watchCancellation observes cancellation")
+	}
+
+	// A subsequent explicit Close must be a safe, idempotent no-op.
+	if err := stream.Close(); err != nil {
+		t.Fatalf("Close after watchCancellation returned unexpected error: %v", err)
+	}
+}
@@ -1,19 +1,20 @@
 package xweb
 
 import (
-	"crypto/md5"
 	"fmt"
-	"io"
+	"net/http"
 	"os"
 	"path/filepath"
-	"sync"
 
 	"github.com/howeyc/fsnotify"
 )
 
 type StaticVerMgr struct {
-	Caches  map[string]string
-	mutex   *sync.Mutex
+	// Backend computes versions (and, depending on the implementation,
+	// integrity hashes and precompressed variants) for files under Path.
+	// Defaults to NewMD5Backend() if left nil before Init is called; set
+	// it beforehand (e.g. to NewSHABackend()) to plug in a different one.
+	Backend StaticVerBackend
 	Path    string
 	Ignores map[string]bool
 	app     *App
@@ -36,6 +37,9 @@ func (self *StaticVerMgr) Moniter(staticPath string) error {
 				if _, ok := self.Ignores[filepath.Base(ev.Name)]; ok {
 					break
 				}
+				if isPrecompressedPath(ev.Name) {
+					break
+				}
 				d, err := os.Stat(ev.Name)
 				if err != nil {
 					break
@@ -95,11 +99,14 @@ func (self *StaticVerMgr) Moniter(staticPath string) error {
 
 func (self *StaticVerMgr) Init(app *App, staticPath string) error {
 	self.Path = staticPath
-	self.Caches = make(map[string]string)
-	self.mutex = &sync.Mutex{}
 	self.Ignores = map[string]bool{".DS_Store": true}
 	self.app = app
 
+	if self.Backend == nil {
+		self.Backend = NewMD5Backend()
+	}
+	self.Backend.Init(staticPath, self.Ignores)
+
 	if dirExists(staticPath) {
 		self.CacheAll(staticPath)
 
@@ -109,77 +116,116 @@ func (self *StaticVerMgr) Init(app *App, staticPath string) error {
 	return nil
 }
 
-func (self *StaticVerMgr) getFileVer(url string) string {
-	//content, err := ioutil.ReadFile(path.Join(self.Path, url))
-	fPath := filepath.Join(self.Path, url)
-	self.app.Debug("loaded static ", fPath)
-	f, err := os.Open(fPath)
+func (self *StaticVerMgr) CacheAll(staticPath string) error {
+	self.app.Debug("loading static file versions from ", staticPath)
+	return self.Backend.CacheAll()
+}
+
+// GetVersion returns the cache-busting version string for url, computing
+// and caching it on first access.
+func (self *StaticVerMgr) GetVersion(url string) string {
+	return self.Backend.Version(url)
+}
+
+// GetIntegrity returns a Subresource Integrity string (e.g.
+// "sha384-...") for url, or "" if the configured Backend doesn't support
+// it (MD5Backend, the default, never does; use NewSHABackend instead).
+func (self *StaticVerMgr) GetIntegrity(url string) string {
+	return self.Backend.Integrity(url)
+}
+
+// GetVariants returns the precompressed siblings (foo.js.gz, foo.js.br,
+// ...) known for url.
+func (self *StaticVerMgr) GetVariants(url string) []Variant {
+	return self.Backend.Variants(url)
+}
+
+func (self *StaticVerMgr) CacheDelete(url string) {
+	self.Backend.CacheDelete(url)
+	self.app.Infof("static file %s is deleted.\n", url)
+}
+
+func (self *StaticVerMgr) CacheItem(url string) {
+	self.Backend.CacheItem(url)
+	self.app.Infof("static file %s is created.", url)
+}
+
+// ServeStatic serves the file named by url (relative to Path) to req,
+// picking the smallest precompressed Variant whose encoding is accepted
+// by the client's Accept-Encoding header and falling back to the
+// uncompressed file otherwise. It routes through ResponseWriter.ServeContent
+// so range requests and conditional GETs work the same as for any other
+// static asset.
+func (self *StaticVerMgr) ServeStatic(w *ResponseWriter, req *http.Request, url string) error {
+	path := filepath.Join(self.Path, url)
+	encoding := ""
+
+	if variants := self.Backend.Variants(url); len(variants) > 0 {
+		if v := pickVariant(variants, req.Header.Get("Accept-Encoding")); v != nil {
+			path = v.Path
+			encoding = v.Encoding
+		}
+	}
+
+	f, err := os.Open(path)
 	if err != nil {
-		return ""
+		return err
 	}
 	defer f.Close()
 
-	fInfo, err := f.Stat()
+	fi, err := f.Stat()
 	if err != nil {
-		return ""
+		return err
 	}
 
-	content := make([]byte, int(fInfo.Size()))
-	_, err = f.Read(content)
-	if err == nil {
-		h := md5.New()
-		io.WriteString(h, string(content))
-		return fmt.Sprintf("%x", h.Sum(nil))[0:4]
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
 	}
-	return ""
-}
+	w.Header().Add("Vary", "Accept-Encoding")
 
-func (self *StaticVerMgr) CacheAll(staticPath string) error {
-	self.mutex.Lock()
-	defer self.mutex.Unlock()
-	//fmt.Print("Getting static file version number, please wait... ")
-	err := filepath.Walk(staticPath, func(f string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-		rp := f[len(staticPath)+1:]
-		if _, ok := self.Ignores[filepath.Base(rp)]; !ok {
-			self.Caches[rp] = self.getFileVer(rp)
-		}
-		return nil
-	})
-	//fmt.Println("Complete.")
-	return err
+	if etag := self.etagFor(url); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+
+	return w.ServeContent(req, filepath.Base(url), fi.ModTime(), f)
 }
 
-func (self *StaticVerMgr) GetVersion(url string) string {
-	self.mutex.Lock()
-	defer self.mutex.Unlock()
-	if ver, ok := self.Caches[url]; ok {
-		return ver
+// etagFor builds the ETag ServeStatic sets before calling ServeContent,
+// preferring the Backend's Subresource Integrity hash (its characters are
+// all valid inside an ETag's quoted string) and falling back to its version
+// string when the Backend doesn't support integrity hashes.
+func (self *StaticVerMgr) etagFor(url string) string {
+	if integrity := self.Backend.Integrity(url); integrity != "" {
+		return `"` + integrity + `"`
 	}
-
-	ver := self.getFileVer(url)
-	if ver != "" {
-		self.Caches[url] = ver
+	if version := self.Backend.Version(url); version != "" {
+		return `"` + version + `"`
 	}
-	return ver
+	return ""
 }
 
-func (self *StaticVerMgr) CacheDelete(url string) {
-	self.mutex.Lock()
-	defer self.mutex.Unlock()
-	delete(self.Caches, url)
-	self.app.Infof("static file %s is deleted.\n", url)
-}
+// pickVariant returns the smallest of variants whose Encoding has a
+// positive quality value in acceptEncoding, or nil if none match.
+func pickVariant(variants []Variant, acceptEncoding string) *Variant {
+	if acceptEncoding == "" {
+		return nil
+	}
+	accepted := make(map[string]bool)
+	for _, spec := range parseAccept(acceptEncoding) {
+		if spec.q > 0 {
+			accepted[spec.mimeType] = true
+		}
+	}
 
-func (self *StaticVerMgr) CacheItem(url string) {
-	fmt.Println(url)
-	ver := self.getFileVer(url)
-	if ver != "" {
-		self.mutex.Lock()
-		defer self.mutex.Unlock()
-		self.Caches[url] = ver
-		self.app.Infof("static file %s is created.", url)
+	var best *Variant
+	for i := range variants {
+		v := &variants[i]
+		if !accepted[v.Encoding] {
+			continue
+		}
+		if best == nil || v.Size < best.Size {
+			best = v
+		}
 	}
+	return best
 }
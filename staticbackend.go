@@ -0,0 +1,282 @@
+package xweb
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Variant describes a precompressed sibling of a static file (e.g.
+// foo.js.gz or foo.js.br next to foo.js) that can be served directly,
+// with the matching Content-Encoding, when the client advertises support
+// for it in Accept-Encoding.
+type Variant struct {
+	Encoding string // "gzip", "br", ...
+	Path     string // absolute path to the precompressed file on disk
+	Size     int64
+}
+
+// precompressedSuffixes maps the file extension of a precompressed
+// sibling to the Content-Encoding token it should be served with.
+var precompressedSuffixes = map[string]string{
+	".gz": "gzip",
+	".br": "br",
+}
+
+// scanVariants looks for fPath+".gz"/".br" siblings of fPath and returns
+// whichever of them exist.
+func scanVariants(fPath string) []Variant {
+	var variants []Variant
+	for suffix, encoding := range precompressedSuffixes {
+		vp := fPath + suffix
+		if fi, err := os.Stat(vp); err == nil && !fi.IsDir() {
+			variants = append(variants, Variant{Encoding: encoding, Path: vp, Size: fi.Size()})
+		}
+	}
+	return variants
+}
+
+func isPrecompressedPath(p string) bool {
+	for suffix := range precompressedSuffixes {
+		if strings.HasSuffix(p, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// StaticVerBackend computes the cache-busting version string for a
+// static file addressed by its URL relative to the static root, and
+// optionally a Subresource Integrity digest and precompressed variants.
+// StaticVerMgr ships two implementations: MD5Backend (the default,
+// preserving the original behavior) and SHABackend, which also exposes
+// SRI hashes via Integrity.
+type StaticVerBackend interface {
+	// Init is called once, with the static root and the set of file
+	// names to skip, before CacheAll/CacheItem/CacheDelete are used.
+	Init(staticPath string, ignores map[string]bool)
+	Version(url string) string
+	Integrity(url string) string
+	Variants(url string) []Variant
+	CacheAll() error
+	CacheItem(url string)
+	CacheDelete(url string)
+}
+
+// MD5Backend is the original StaticVerMgr implementation: a short MD5
+// prefix used purely to bust browser caches. Integrity always returns "".
+type MD5Backend struct {
+	path     string
+	ignores  map[string]bool
+	mutex    sync.Mutex
+	versions map[string]string
+	variants map[string][]Variant
+}
+
+// NewMD5Backend returns the default StaticVerBackend.
+func NewMD5Backend() *MD5Backend {
+	return &MD5Backend{
+		versions: make(map[string]string),
+		variants: make(map[string][]Variant),
+	}
+}
+
+func (b *MD5Backend) Init(staticPath string, ignores map[string]bool) {
+	b.path = staticPath
+	b.ignores = ignores
+}
+
+func (b *MD5Backend) hash(fPath string) string {
+	f, err := os.Open(fPath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))[0:4]
+}
+
+func (b *MD5Backend) Version(url string) string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if ver, ok := b.versions[url]; ok {
+		return ver
+	}
+	ver := b.hash(filepath.Join(b.path, url))
+	if ver != "" {
+		b.versions[url] = ver
+		b.variants[url] = scanVariants(filepath.Join(b.path, url))
+	}
+	return ver
+}
+
+func (b *MD5Backend) Integrity(url string) string {
+	return ""
+}
+
+func (b *MD5Backend) Variants(url string) []Variant {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.variants[url]
+}
+
+func (b *MD5Backend) CacheAll() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return filepath.Walk(b.path, func(f string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || isPrecompressedPath(f) {
+			return err
+		}
+		rp := f[len(b.path)+1:]
+		if _, ok := b.ignores[filepath.Base(rp)]; ok {
+			return nil
+		}
+		b.versions[rp] = b.hash(f)
+		b.variants[rp] = scanVariants(f)
+		return nil
+	})
+}
+
+func (b *MD5Backend) CacheItem(url string) {
+	fPath := filepath.Join(b.path, url)
+	ver := b.hash(fPath)
+	variants := scanVariants(fPath)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if ver != "" {
+		b.versions[url] = ver
+	}
+	b.variants[url] = variants
+}
+
+func (b *MD5Backend) CacheDelete(url string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.versions, url)
+	delete(b.variants, url)
+}
+
+// SHABackend computes SHA-256 cache-busting versions and SHA-384
+// Subresource Integrity digests, so templates can emit
+// <script integrity="sha384-...">  tags alongside the versioned URL.
+type SHABackend struct {
+	path      string
+	ignores   map[string]bool
+	mutex     sync.Mutex
+	versions  map[string]string
+	integrity map[string]string
+	variants  map[string][]Variant
+}
+
+// NewSHABackend returns a StaticVerBackend that additionally exposes SRI
+// hashes through Integrity.
+func NewSHABackend() *SHABackend {
+	return &SHABackend{
+		versions:  make(map[string]string),
+		integrity: make(map[string]string),
+		variants:  make(map[string][]Variant),
+	}
+}
+
+func (b *SHABackend) Init(staticPath string, ignores map[string]bool) {
+	b.path = staticPath
+	b.ignores = ignores
+}
+
+// digest returns the short cache-busting version (a SHA-256 prefix) and
+// the sha384-... Subresource Integrity string for fPath.
+func (b *SHABackend) digest(fPath string) (version, integrity string) {
+	f, err := os.Open(fPath)
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+
+	h256 := sha256.New()
+	h384 := sha512.New384()
+	if _, err := io.Copy(io.MultiWriter(h256, h384), f); err != nil {
+		return "", ""
+	}
+	version = fmt.Sprintf("%x", h256.Sum(nil))[0:8]
+	integrity = "sha384-" + base64.StdEncoding.EncodeToString(h384.Sum(nil))
+	return version, integrity
+}
+
+func (b *SHABackend) cache(url, fPath string) (version string) {
+	version, integrity := b.digest(fPath)
+	variants := scanVariants(fPath)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if version != "" {
+		b.versions[url] = version
+		b.integrity[url] = integrity
+	}
+	b.variants[url] = variants
+	return version
+}
+
+func (b *SHABackend) Version(url string) string {
+	b.mutex.Lock()
+	if ver, ok := b.versions[url]; ok {
+		b.mutex.Unlock()
+		return ver
+	}
+	b.mutex.Unlock()
+	return b.cache(url, filepath.Join(b.path, url))
+}
+
+func (b *SHABackend) Integrity(url string) string {
+	b.mutex.Lock()
+	if integrity, ok := b.integrity[url]; ok {
+		b.mutex.Unlock()
+		return integrity
+	}
+	b.mutex.Unlock()
+	b.cache(url, filepath.Join(b.path, url))
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.integrity[url]
+}
+
+func (b *SHABackend) Variants(url string) []Variant {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.variants[url]
+}
+
+func (b *SHABackend) CacheAll() error {
+	return filepath.Walk(b.path, func(f string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || isPrecompressedPath(f) {
+			return err
+		}
+		rp := f[len(b.path)+1:]
+		if _, ok := b.ignores[filepath.Base(rp)]; ok {
+			return nil
+		}
+		b.cache(rp, f)
+		return nil
+	})
+}
+
+func (b *SHABackend) CacheItem(url string) {
+	b.cache(url, filepath.Join(b.path, url))
+}
+
+func (b *SHABackend) CacheDelete(url string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.versions, url)
+	delete(b.integrity, url)
+	delete(b.variants, url)
+}
@@ -0,0 +1,76 @@
+// Package webdav implements a WebDAV server (RFC 4918) on top of xweb
+// actions and xweb.ResponseWriter.
+package webdav
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// File is the interface satisfied by an open file or directory handle
+// returned from FileSystem.OpenFile.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	io.Closer
+	Readdir(count int) ([]os.FileInfo, error)
+	Stat() (os.FileInfo, error)
+}
+
+// FileSystem is the interface a DavAction uses to resolve the paths a
+// WebDAV client sends it to actual storage. It is intentionally close to
+// the subset of os/io that a database- or object-store-backed
+// implementation can realistically provide.
+type FileSystem interface {
+	Stat(name string) (os.FileInfo, error)
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Mkdir(name string, perm os.FileMode) error
+	Rename(oldName, newName string) error
+	Remove(name string) error
+	Walk(root string, fn filepath.WalkFunc) error
+}
+
+// Dir implements FileSystem by serving files from a directory on local
+// disk, rooted at the directory its value names. It is the default FileSystem for a DavAction
+// that doesn't set one explicitly.
+type Dir string
+
+func (d Dir) resolve(name string) string {
+	return filepath.Join(string(d), filepath.FromSlash(filepath.Clean("/"+name)))
+}
+
+func (d Dir) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(d.resolve(name))
+}
+
+func (d Dir) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return os.OpenFile(d.resolve(name), flag, perm)
+}
+
+func (d Dir) Mkdir(name string, perm os.FileMode) error {
+	return os.Mkdir(d.resolve(name), perm)
+}
+
+func (d Dir) Rename(oldName, newName string) error {
+	return os.Rename(d.resolve(oldName), d.resolve(newName))
+}
+
+func (d Dir) Remove(name string) error {
+	return os.RemoveAll(d.resolve(name))
+}
+
+func (d Dir) Walk(root string, fn filepath.WalkFunc) error {
+	base := d.resolve(root)
+	return filepath.Walk(base, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fn(p, info, err)
+		}
+		rel, err := filepath.Rel(string(d), p)
+		if err != nil {
+			return err
+		}
+		return fn("/"+filepath.ToSlash(rel), info, nil)
+	})
+}
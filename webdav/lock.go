@@ -0,0 +1,177 @@
+package webdav
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrLocked is returned by LockSystem.Create and Confirm when the
+// resource in question is already locked by someone else.
+var ErrLocked = errors.New("webdav: locked")
+
+// ErrNoSuchLock is returned by Refresh and Unlock when token doesn't
+// name a held lock (it never existed, or it already expired/was
+// unlocked).
+var ErrNoSuchLock = errors.New("webdav: no such lock")
+
+// LockDetails describes the lock a client asked to create via a LOCK
+// request.
+type LockDetails struct {
+	Root      string        // path the lock applies to
+	Duration  time.Duration // 0 means infinite
+	OwnerXML  string        // raw <owner> element from the request body
+	ZeroDepth bool          // Depth: 0 vs Depth: infinity
+}
+
+// Condition is a single entry of a client's "If" header: either a lock
+// token, optionally negated.
+type Condition struct {
+	Not   bool
+	Token string
+}
+
+// LockSystem tracks the WebDAV locks held across resources. xweb ships
+// NewMemLS, an in-memory implementation; back it with a database or
+// object store by implementing this interface yourself and assigning it
+// to DavAction.Locks.
+type LockSystem interface {
+	// Confirm reports whether the locks held on root0 (and root1, for
+	// methods like COPY/MOVE that touch two resources) are satisfied by
+	// conditions, returning a release func to call once the caller is
+	// done, or ErrLocked if they aren't.
+	Confirm(now time.Time, root0, root1 string, conditions ...Condition) (release func(), err error)
+	// Create creates a lock and returns its token.
+	Create(now time.Time, details LockDetails) (token string, err error)
+	// Refresh extends a held lock's expiry.
+	Refresh(now time.Time, token string, duration time.Duration) (LockDetails, error)
+	// Unlock releases a held lock.
+	Unlock(now time.Time, token string) error
+}
+
+type memLSNode struct {
+	details LockDetails
+	expiry  time.Time
+}
+
+// memLS is a simple, process-local LockSystem: it hands out exclusive
+// locks per path, compared exactly as given, with no lock-tree/shared-lock semantics.
+// That's enough to make clients that check before writing (most WebDAV
+// clients do) behave, without the bookkeeping a fully RFC-4918-compliant
+// lock tree would need.
+type memLS struct {
+	mutex sync.Mutex
+	locks map[string]*memLSNode // token -> node
+	byRoot map[string]string    // root -> token
+}
+
+// NewMemLS returns an in-memory LockSystem.
+func NewMemLS() LockSystem {
+	return &memLS{
+		locks:  make(map[string]*memLSNode),
+		byRoot: make(map[string]string),
+	}
+}
+
+func genToken() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("opaquelocktoken:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func (m *memLS) purgeExpiredLocked(now time.Time) {
+	for token, node := range m.locks {
+		if !node.expiry.IsZero() && now.After(node.expiry) {
+			delete(m.locks, token)
+			delete(m.byRoot, node.details.Root)
+		}
+	}
+}
+
+func (m *memLS) heldByOther(now time.Time, root string, allowed map[string]bool) bool {
+	m.purgeExpiredLocked(now)
+	for r, token := range m.byRoot {
+		if r != root {
+			continue
+		}
+		if !allowed[token] {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memLS) Confirm(now time.Time, root0, root1 string, conditions ...Condition) (func(), error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	allowed := make(map[string]bool)
+	for _, c := range conditions {
+		if !c.Not {
+			allowed[strings.TrimSpace(c.Token)] = true
+		}
+	}
+
+	if m.heldByOther(now, root0, allowed) {
+		return nil, ErrLocked
+	}
+	if root1 != "" && root1 != root0 && m.heldByOther(now, root1, allowed) {
+		return nil, ErrLocked
+	}
+
+	return func() {}, nil
+}
+
+func (m *memLS) Create(now time.Time, details LockDetails) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.purgeExpiredLocked(now)
+	if _, held := m.byRoot[details.Root]; held {
+		return "", ErrLocked
+	}
+
+	token := genToken()
+	node := &memLSNode{details: details}
+	if details.Duration > 0 {
+		node.expiry = now.Add(details.Duration)
+	}
+	m.locks[token] = node
+	m.byRoot[details.Root] = token
+	return token, nil
+}
+
+func (m *memLS) Refresh(now time.Time, token string, duration time.Duration) (LockDetails, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.purgeExpiredLocked(now)
+	node, ok := m.locks[token]
+	if !ok {
+		return LockDetails{}, ErrNoSuchLock
+	}
+	node.details.Duration = duration
+	if duration > 0 {
+		node.expiry = now.Add(duration)
+	} else {
+		node.expiry = time.Time{}
+	}
+	return node.details, nil
+}
+
+func (m *memLS) Unlock(now time.Time, token string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.purgeExpiredLocked(now)
+	node, ok := m.locks[token]
+	if !ok {
+		return ErrNoSuchLock
+	}
+	delete(m.locks, token)
+	delete(m.byRoot, node.details.Root)
+	return nil
+}
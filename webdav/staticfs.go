@@ -0,0 +1,64 @@
+package webdav
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/lunny/xweb"
+)
+
+var errReadOnly = errors.New("webdav: read-only file system")
+
+// staticFS adapts a *xweb.StaticVerMgr's static root as a read-only
+// FileSystem, so its cached/versioned assets can be browsed over WebDAV
+// without duplicating them anywhere.
+type staticFS struct {
+	mgr *xweb.StaticVerMgr
+}
+
+// NewStaticFileSystem wraps mgr.Path as a read-only FileSystem.
+func NewStaticFileSystem(mgr *xweb.StaticVerMgr) FileSystem {
+	return staticFS{mgr: mgr}
+}
+
+func (s staticFS) resolve(name string) string {
+	return filepath.Join(s.mgr.Path, filepath.FromSlash(filepath.Clean("/"+name)))
+}
+
+func (s staticFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(s.resolve(name))
+}
+
+func (s staticFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, errReadOnly
+	}
+	return os.OpenFile(s.resolve(name), os.O_RDONLY, 0)
+}
+
+func (s staticFS) Mkdir(name string, perm os.FileMode) error {
+	return errReadOnly
+}
+
+func (s staticFS) Rename(oldName, newName string) error {
+	return errReadOnly
+}
+
+func (s staticFS) Remove(name string) error {
+	return errReadOnly
+}
+
+func (s staticFS) Walk(root string, fn filepath.WalkFunc) error {
+	base := s.resolve(root)
+	return filepath.Walk(base, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fn(p, info, err)
+		}
+		rel, err := filepath.Rel(s.mgr.Path, p)
+		if err != nil {
+			return err
+		}
+		return fn("/"+filepath.ToSlash(rel), info, nil)
+	})
+}
@@ -0,0 +1,487 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lunny/xweb"
+)
+
+// DavAction is the base type for a WebDAV endpoint. Embed it in an xweb
+// action and xweb's router dispatches PROPFIND/MKCOL/... requests to the
+// method of the matching name, the same way it dispatches Get/Post for
+// plain HTTP verbs.
+//
+//	type Files struct {
+//		webdav.DavAction
+//	}
+//
+// FS defaults to Dir(".") if left nil, and Locks to a shared package-level
+// memLS if left nil, so the zero value serves the current directory with
+// simple locking shared across every DavAction instance in the process.
+type DavAction struct {
+	FS FileSystem
+	// Locks is the LockSystem backing the Lock/Unlock methods below;
+	// it's named Locks rather than Lock to avoid colliding with the
+	// Lock() action method.
+	Locks LockSystem
+	// Prefix is stripped from the request URL before it is resolved
+	// against FS, mirroring how the resource is mounted in the router.
+	Prefix string
+
+	resp *xweb.ResponseWriter
+}
+
+// defaultLockSystem backs every DavAction whose Locks field is left nil.
+// It must be package-level rather than lazily created per action: xweb's
+// router instantiates a fresh zero-valued DavAction for every request, so
+// a lock system assigned to d.Locks there would never outlive the request
+// that created it, making Lock/Unlock a no-op across the separate requests
+// real WebDAV clients always use them in.
+var defaultLockSystem = NewMemLS()
+
+// SetResponse implements xweb.ResponseInterface, wiring DavAction into
+// the existing Responses interceptor the same way any other action is.
+func (d *DavAction) SetResponse(w *xweb.ResponseWriter) {
+	d.resp = w
+}
+
+func (d *DavAction) fs() FileSystem {
+	if d.FS != nil {
+		return d.FS
+	}
+	return Dir(".")
+}
+
+func (d *DavAction) lockSystem() LockSystem {
+	if d.Locks != nil {
+		return d.Locks
+	}
+	return defaultLockSystem
+}
+
+func (d *DavAction) stripPrefix(p string) string {
+	if d.Prefix == "" {
+		return p
+	}
+	if r := strings.TrimPrefix(p, d.Prefix); len(r) < len(p) {
+		if r == "" {
+			return "/"
+		}
+		return r
+	}
+	return p
+}
+
+func (d *DavAction) req() *http.Request {
+	return d.resp.Request()
+}
+
+func (d *DavAction) name() string {
+	return d.stripPrefix(d.req().URL.Path)
+}
+
+func (d *DavAction) abort(status int, msg string) error {
+	return d.resp.Abort(status, msg)
+}
+
+// Options answers an OPTIONS request by listing the WebDAV methods this
+// action understands.
+func (d *DavAction) Options() error {
+	d.resp.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, PROPPATCH, MKCOL, COPY, MOVE, LOCK, UNLOCK")
+	d.resp.Header().Set("DAV", "1, 2")
+	d.resp.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// Get serves the resource's contents, routed through ServeContent so
+// ranged/conditional GETs work the same as any other xweb download.
+func (d *DavAction) Get() error {
+	name := d.name()
+	fi, err := d.fs().Stat(name)
+	if err != nil {
+		return d.abort(http.StatusNotFound, err.Error())
+	}
+	if fi.IsDir() {
+		return d.abort(http.StatusMethodNotAllowed, "cannot GET a collection")
+	}
+
+	f, err := d.fs().OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return d.abort(http.StatusNotFound, err.Error())
+	}
+	defer f.Close()
+
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		return d.abort(http.StatusInternalServerError, "file does not support seeking")
+	}
+	return d.resp.ServeContent(d.req(), path.Base(name), fi.ModTime(), rs)
+}
+
+// Head reports the resource's metadata without a body.
+func (d *DavAction) Head() error {
+	name := d.name()
+	fi, err := d.fs().Stat(name)
+	if err != nil {
+		return d.abort(http.StatusNotFound, err.Error())
+	}
+	if fi.IsDir() {
+		return d.abort(http.StatusMethodNotAllowed, "cannot HEAD a collection")
+	}
+	d.resp.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	d.resp.Header().Set("Last-Modified", fi.ModTime().UTC().Format(http.TimeFormat))
+	d.resp.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// Put creates or overwrites the resource with the request body.
+func (d *DavAction) Put() error {
+	if release, err := d.confirmLocks(); err != nil {
+		return d.abort(http.StatusLocked, err.Error())
+	} else {
+		defer release()
+	}
+
+	name := d.name()
+	f, err := d.fs().OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return d.abort(http.StatusConflict, err.Error())
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, d.req().Body); err != nil {
+		return d.abort(http.StatusInternalServerError, err.Error())
+	}
+	d.resp.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// Delete removes the resource.
+func (d *DavAction) Delete() error {
+	if release, err := d.confirmLocks(); err != nil {
+		return d.abort(http.StatusLocked, err.Error())
+	} else {
+		defer release()
+	}
+
+	name := d.name()
+	if _, err := d.fs().Stat(name); err != nil {
+		return d.abort(http.StatusNotFound, err.Error())
+	}
+	if err := d.fs().Remove(name); err != nil {
+		return d.abort(http.StatusInternalServerError, err.Error())
+	}
+	d.resp.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Mkcol creates a collection (directory).
+func (d *DavAction) Mkcol() error {
+	if release, err := d.confirmLocks(); err != nil {
+		return d.abort(http.StatusLocked, err.Error())
+	} else {
+		defer release()
+	}
+
+	if d.req().ContentLength > 0 {
+		return d.abort(http.StatusUnsupportedMediaType, "MKCOL does not take a body")
+	}
+	if err := d.fs().Mkdir(d.name(), 0755); err != nil {
+		if os.IsNotExist(err) {
+			return d.abort(http.StatusConflict, err.Error())
+		}
+		return d.abort(http.StatusMethodNotAllowed, err.Error())
+	}
+	d.resp.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func (d *DavAction) destination() (string, error) {
+	dest := d.req().Header.Get("Destination")
+	if dest == "" {
+		return "", d.abort(http.StatusBadRequest, "missing Destination header")
+	}
+	if u, err := url.Parse(dest); err == nil {
+		dest = u.Path
+	}
+	return d.stripPrefix(dest), nil
+}
+
+// Copy duplicates the resource named by the request URL to the
+// Destination header. Depth: infinity is the only recursive mode xweb
+// implements, matching what every client actually sends for COPY.
+func (d *DavAction) Copy() error {
+	return d.copyOrMove(false)
+}
+
+// Move renames the resource named by the request URL to the Destination
+// header.
+func (d *DavAction) Move() error {
+	return d.copyOrMove(true)
+}
+
+func (d *DavAction) copyOrMove(move bool) error {
+	src := d.name()
+	dst, err := d.destination()
+	if err != nil {
+		return err
+	}
+
+	if release, err := d.lockSystem().Confirm(time.Now(), src, dst, d.conditions()...); err != nil {
+		return d.abort(http.StatusLocked, err.Error())
+	} else {
+		defer release()
+	}
+
+	overwrite := d.req().Header.Get("Overwrite") != "F"
+	if _, err := d.fs().Stat(dst); err == nil && !overwrite {
+		return d.abort(http.StatusPreconditionFailed, "destination exists")
+	}
+
+	if move {
+		if err := d.fs().Rename(src, dst); err != nil {
+			return d.abort(http.StatusInternalServerError, err.Error())
+		}
+		d.resp.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	if err := copyTree(d.fs(), src, dst); err != nil {
+		return d.abort(http.StatusInternalServerError, err.Error())
+	}
+	d.resp.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func copyTree(fs FileSystem, src, dst string) error {
+	fi, err := fs.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		in, err := fs.OpenFile(src, os.O_RDONLY, 0)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := fs.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_TRUNC, fi.Mode())
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		_, err = io.Copy(out, in)
+		return err
+	}
+
+	if err := fs.Mkdir(dst, fi.Mode()); err != nil && !os.IsExist(err) {
+		return err
+	}
+	children, err := readChildren(fs, src)
+	if err != nil {
+		return err
+	}
+	for _, name := range children {
+		if err := copyTree(fs, path.Join(src, name), path.Join(dst, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readChildren(fs FileSystem, dir string) ([]string, error) {
+	f, err := fs.OpenFile(dir, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(infos))
+	for i, fi := range infos {
+		names[i] = fi.Name()
+	}
+	return names, nil
+}
+
+// Lock creates or refreshes a lock on the resource.
+func (d *DavAction) Lock() error {
+	req := d.req()
+	refresh := req.Header.Get("If") != ""
+
+	if refresh {
+		token := extractToken(req.Header.Get("If"))
+		if _, err := d.lockSystem().Refresh(time.Now(), token, parseTimeout(req.Header.Get("Timeout"))); err != nil {
+			return d.abort(http.StatusPreconditionFailed, err.Error())
+		}
+		d.resp.Header().Set("Lock-Token", "<"+token+">")
+		return d.resp.ServeXml(lockDiscovery(token))
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return d.abort(http.StatusBadRequest, err.Error())
+	}
+
+	details := LockDetails{
+		Root:      d.name(),
+		Duration:  parseTimeout(req.Header.Get("Timeout")),
+		OwnerXML:  string(body),
+		ZeroDepth: req.Header.Get("Depth") == "0",
+	}
+	token, err := d.lockSystem().Create(time.Now(), details)
+	if err != nil {
+		return d.abort(http.StatusLocked, err.Error())
+	}
+	d.resp.Header().Set("Lock-Token", "<"+token+">")
+	d.resp.WriteHeader(http.StatusOK)
+	return d.resp.ServeXml(lockDiscovery(token))
+}
+
+// Unlock releases a held lock named by the Lock-Token header.
+func (d *DavAction) Unlock() error {
+	token := strings.Trim(d.req().Header.Get("Lock-Token"), "<>")
+	if token == "" {
+		return d.abort(http.StatusBadRequest, "missing Lock-Token header")
+	}
+	if err := d.lockSystem().Unlock(time.Now(), token); err != nil {
+		return d.abort(http.StatusConflict, err.Error())
+	}
+	d.resp.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Propfind answers a PROPFIND request. Depth: 0 reports only the
+// requested resource; Depth: 1 and Depth: infinity walk the tree,
+// streaming each <response> as it's produced (via ResponseWriter's
+// streaming bypass) instead of buffering the whole multistatus body,
+// since a deep tree can be large.
+func (d *DavAction) Propfind() error {
+	name := d.name()
+	fi, err := d.fs().Stat(name)
+	if err != nil {
+		return d.abort(http.StatusNotFound, err.Error())
+	}
+
+	depth := d.req().Header.Get("Depth")
+
+	d.resp.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	d.resp.WriteHeader(http.StatusMultiStatus)
+	d.resp.StartStreaming()
+
+	enc := xml.NewEncoder(d.resp)
+	io.WriteString(d.resp, xml.Header)
+	start := xml.StartElement{Name: xml.Name{Space: "DAV:", Local: "multistatus"}}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	if err := enc.Encode(statResponse(d.req().URL.Path, fi)); err != nil {
+		return err
+	}
+
+	if fi.IsDir() && depth != "0" {
+		root := path.Clean(name)
+		if err := d.fs().Walk(name, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			p = path.Clean(p)
+			if p == root {
+				return nil
+			}
+			if depth == "1" && path.Dir(p) != root {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			href := path.Join(d.req().URL.Path, strings.TrimPrefix(p, root))
+			return enc.Encode(statResponse(href, info))
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := enc.EncodeToken(start.End()); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// Proppatch applies property changes; xweb has no custom dead-property
+// store, so it reports every requested change as 200 OK without storing
+// anything, which is enough for clients that only use PROPPATCH to set
+// metadata they don't read back through this server.
+func (d *DavAction) Proppatch() error {
+	name := d.name()
+	if _, err := d.fs().Stat(name); err != nil {
+		return d.abort(http.StatusNotFound, err.Error())
+	}
+	d.resp.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	d.resp.WriteHeader(http.StatusMultiStatus)
+	return d.resp.ServeXml(davMultistatus{
+		Responses: []davResponse{{
+			Href:     d.req().URL.Path,
+			PropStat: []davPropstat{{Status: "HTTP/1.1 200 OK"}},
+		}},
+	})
+}
+
+func (d *DavAction) conditions() []Condition {
+	raw := d.req().Header.Get("If")
+	if raw == "" {
+		return nil
+	}
+	var conds []Condition
+	for _, tok := range strings.FieldsFunc(raw, func(r rune) bool { return r == '(' || r == ')' || r == ' ' }) {
+		if tok == "" {
+			continue
+		}
+		conds = append(conds, Condition{Not: strings.HasPrefix(tok, "Not"), Token: extractToken(tok)})
+	}
+	return conds
+}
+
+func (d *DavAction) confirmLocks() (func(), error) {
+	return d.lockSystem().Confirm(time.Now(), d.name(), "", d.conditions()...)
+}
+
+func extractToken(s string) string {
+	i := strings.Index(s, "<")
+	j := strings.Index(s, ">")
+	if i < 0 || j < 0 || j < i {
+		return ""
+	}
+	return s[i+1 : j]
+}
+
+func parseTimeout(s string) time.Duration {
+	if !strings.HasPrefix(s, "Second-") {
+		return 0
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(s, "Second-")); err == nil {
+		return time.Duration(n) * time.Second
+	}
+	return 0
+}
+
+type davLockDiscovery struct {
+	XMLName xml.Name `xml:"DAV: prop"`
+	Token   string   `xml:"DAV: lockdiscovery>activelock>locktoken>href"`
+}
+
+func lockDiscovery(token string) davLockDiscovery {
+	return davLockDiscovery{Token: token}
+}
@@ -0,0 +1,54 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"os"
+	"strconv"
+	"time"
+)
+
+// davResourceType renders <resourcetype><collection/></resourcetype> for
+// directories and an empty <resourcetype/> for files. xweb's PROPFIND
+// always reports the same handful of live properties (resourcetype,
+// getcontentlength, getlastmodified) regardless of which properties the
+// client's <prop> list actually asked for, which is enough for the
+// clients that matter: they all fall back to <allprop> behavior anyway.
+type davResourceType struct {
+	Collection *struct{} `xml:"DAV: collection"`
+}
+
+type davProp struct {
+	ResourceType     davResourceType `xml:"DAV: resourcetype"`
+	GetContentLength string          `xml:"DAV: getcontentlength,omitempty"`
+	GetLastModified  string          `xml:"DAV: getlastmodified,omitempty"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"DAV: prop"`
+	Status string  `xml:"DAV: status"`
+}
+
+type davResponse struct {
+	XMLName  xml.Name      `xml:"DAV: response"`
+	Href     string        `xml:"DAV: href"`
+	PropStat []davPropstat `xml:"DAV: propstat"`
+}
+
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"DAV: multistatus"`
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+// statResponse builds the <response> element describing fi at href.
+func statResponse(href string, fi os.FileInfo) davResponse {
+	prop := davProp{GetLastModified: fi.ModTime().UTC().Format(time.RFC1123)}
+	if fi.IsDir() {
+		prop.ResourceType.Collection = &struct{}{}
+	} else {
+		prop.GetContentLength = strconv.FormatInt(fi.Size(), 10)
+	}
+	return davResponse{
+		Href:     href,
+		PropStat: []davPropstat{{Prop: prop, Status: "HTTP/1.1 200 OK"}},
+	}
+}